@@ -84,6 +84,60 @@ func testIntegration(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("using Yarn Berry", func() {
+		it("builds a working image for a PnP app with no node_modules", func() {
+			app, err := dagger.PackBuild(filepath.Join("testdata", "with_yarn_berry_pnp"), nodeURI, yarnURI)
+			Expect(err).ToNot(HaveOccurred())
+			defer app.Destroy()
+
+			Expect(app.Start()).To(Succeed())
+
+			body, _, err := app.HTTPGet("/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(body).To(ContainSubstring("Hello, world!"))
+		})
+
+		it("builds a working image for a Berry app using the node-modules linker", func() {
+			app, err := dagger.PackBuild(filepath.Join("testdata", "with_yarn_berry_node_modules"), nodeURI, yarnURI)
+			Expect(err).ToNot(HaveOccurred())
+			defer app.Destroy()
+
+			Expect(app.Start()).To(Succeed())
+
+			body, _, err := app.HTTPGet("/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(body).To(ContainSubstring("Hello, world!"))
+		})
+
+		it("installs immutably from a vendored Zero-Installs cache", func() {
+			app, err := dagger.PackBuild(filepath.Join("testdata", "zero_installs"), nodeURI, yarnURI)
+			Expect(err).ToNot(HaveOccurred())
+			defer app.Destroy()
+
+			Expect(app.BuildLogs()).To(ContainSubstring("Installing node_modules from vendored Zero-Installs cache"))
+
+			Expect(app.Start()).To(Succeed())
+
+			body, _, err := app.HTTPGet("/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(body).To(ContainSubstring("Hello, world!"))
+		})
+	})
+
+	when("the app declares packageManager: pnpm in its package.json", func() {
+		it("installs with pnpm instead of yarn", func() {
+			app, err := dagger.PackBuild(filepath.Join("testdata", "with_pnpm"), nodeURI, yarnURI)
+			Expect(err).ToNot(HaveOccurred())
+			defer app.Destroy()
+
+			Expect(app.Start()).To(Succeed())
+
+			body, _, err := app.HTTPGet("/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(body).To(ContainSubstring("Hello, world!"))
+		})
+	})
+
 	when("the app is pushed twice", func() {
 		it("does not reinstall node_modules when yarn.lock is not changed", func() {
 			appDir := filepath.Join("testdata", "simple_app")
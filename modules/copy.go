@@ -0,0 +1,193 @@
+package modules
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// DefaultCopyConcurrency bounds how many package subtrees are copied in
+// parallel when the rename fast path isn't available.
+const DefaultCopyConcurrency = 8
+
+// CopyOptions controls how contributeNodeModules moves the installed
+// dependency tree from the app directory into its layer.
+type CopyOptions struct {
+	// Concurrency is the number of worker goroutines used to copy package
+	// subtrees in parallel. Zero means DefaultCopyConcurrency.
+	Concurrency int
+	// UseRename attempts a single os.Rename of the whole tree before
+	// falling back to a walking copy, which is dramatically faster when
+	// src and dst share a filesystem (the common case: both layers live
+	// under the same builder-provided /layers volume).
+	UseRename bool
+}
+
+func (o CopyOptions) concurrency() int {
+	if o.Concurrency > 0 {
+		return o.Concurrency
+	}
+
+	return DefaultCopyConcurrency
+}
+
+// moveTree relocates src to dst, preferring a rename and falling back to a
+// concurrent, per-subdirectory copy-then-remove when src and dst aren't on
+// the same filesystem (os.Rename returns *LinkError wrapping EXDEV there) or
+// when dst is already a non-empty directory (a Cache-flagged layer persists
+// its node_modules from a previous build, so rename fails with ENOTEMPTY).
+func moveTree(src, dst string, opts CopyOptions) error {
+	if opts.UseRename {
+		err := os.Rename(src, dst)
+		if err == nil {
+			return nil
+		}
+
+		if isNotEmpty(err) {
+			if err := os.RemoveAll(dst); err != nil {
+				return err
+			}
+			err = os.Rename(src, dst)
+		}
+
+		if err == nil {
+			return nil
+		} else if !isCrossDevice(err) {
+			return err
+		}
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		if err := copyTreeConcurrently(src, dst, opts.concurrency()); err != nil {
+			return err
+		}
+	} else if err := copyFile(src, dst, info); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(src)
+}
+
+// copyTreeConcurrently copies the entries of src into dst, one worker per
+// top-level entry (e.g. each node_modules package) up to concurrency
+// workers at a time, rather than walking the whole tree on a single
+// goroutine.
+func copyTreeConcurrently(src, dst string, concurrency int) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	sem := make(chan struct{}, concurrency)
+	errs := make(chan error, len(entries))
+	var wg sync.WaitGroup
+
+	for _, entry := range entries {
+		entry := entry
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			errs <- copyEntry(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name()), entry)
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyEntry(src, dst string, info os.FileInfo) error {
+	if info.IsDir() {
+		return copyDirRecursive(src, dst)
+	}
+
+	return copyFile(src, dst, info)
+}
+
+func copyDirRecursive(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dst, info.Mode()); err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		srcPath := filepath.Join(src, entry.Name())
+		dstPath := filepath.Join(dst, entry.Name())
+
+		if entry.IsDir() {
+			if err := copyDirRecursive(srcPath, dstPath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := copyFile(srcPath, dstPath, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyFile(src, dst string, info os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
+
+func isCrossDevice(err error) bool {
+	var linkErr *os.LinkError
+	return errors.As(err, &linkErr) && errors.Is(linkErr.Err, syscall.EXDEV)
+}
+
+// isNotEmpty reports whether err is os.Rename failing because dst is a
+// non-empty directory. Per rename(2) this is ENOTEMPTY or EEXIST depending
+// on the filesystem (e.g. overlayfs returns EEXIST).
+func isNotEmpty(err error) bool {
+	var linkErr *os.LinkError
+	return errors.As(err, &linkErr) && (errors.Is(linkErr.Err, syscall.ENOTEMPTY) || errors.Is(linkErr.Err, syscall.EEXIST))
+}
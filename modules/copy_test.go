@@ -0,0 +1,129 @@
+package modules
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestUnitCopy(t *testing.T) {
+	spec.Run(t, "Copy", testCopy, spec.Report(report.Terminal{}))
+}
+
+func testCopy(t *testing.T, when spec.G, it spec.S) {
+	var (
+		Expect   func(interface{}, ...interface{}) GomegaAssertion
+		src, dst string
+	)
+
+	it.Before(func() {
+		Expect = NewWithT(t).Expect
+
+		var err error
+		src, err = ioutil.TempDir("", "copy-src")
+		Expect(err).NotTo(HaveOccurred())
+		dst, err = ioutil.TempDir("", "copy-dst")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.RemoveAll(dst)).To(Succeed())
+
+		Expect(os.MkdirAll(filepath.Join(src, "lodash"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(src, "lodash", "index.js"), []byte("module.exports = {}"), 0644)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(src, ".bin"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(src, ".bin", "tool"), []byte("#!/bin/sh"), 0755)).To(Succeed())
+	})
+
+	it.After(func() {
+		Expect(os.RemoveAll(src)).To(Succeed())
+		Expect(os.RemoveAll(dst)).To(Succeed())
+	})
+
+	when("UseRename is set", func() {
+		it("renames the whole tree and removes the source", func() {
+			Expect(moveTree(src, dst, CopyOptions{UseRename: true})).To(Succeed())
+
+			out, err := ioutil.ReadFile(filepath.Join(dst, "lodash", "index.js"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(out)).To(Equal("module.exports = {}"))
+
+			_, err = os.Stat(src)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		it("succeeds when dst already exists as a non-empty directory from a previous build", func() {
+			Expect(os.MkdirAll(filepath.Join(dst, "stale-package"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dst, "stale-package", "index.js"), []byte("stale"), 0644)).To(Succeed())
+
+			Expect(moveTree(src, dst, CopyOptions{UseRename: true})).To(Succeed())
+
+			out, err := ioutil.ReadFile(filepath.Join(dst, "lodash", "index.js"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(out)).To(Equal("module.exports = {}"))
+
+			_, err = os.Stat(filepath.Join(dst, "stale-package"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	when("UseRename is not set", func() {
+		it("copies every entry concurrently and removes the source", func() {
+			Expect(moveTree(src, dst, CopyOptions{UseRename: false, Concurrency: 2})).To(Succeed())
+
+			out, err := ioutil.ReadFile(filepath.Join(dst, "lodash", "index.js"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(out)).To(Equal("module.exports = {}"))
+
+			binInfo, err := os.Stat(filepath.Join(dst, ".bin", "tool"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binInfo.Mode().Perm() & 0100).NotTo(BeZero())
+
+			_, err = os.Stat(src)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+}
+
+// BenchmarkMoveTree measures the concurrent copy fallback against a tree of
+// many small files, standing in for a large node_modules install. Scaled
+// down from the ~50k files a real install can produce to keep `go test
+// -bench` fast; Concurrency/UseRename can be tweaked here to compare against
+// production-sized fixtures.
+func BenchmarkMoveTree(b *testing.B) {
+	const packages, filesPerPackage = 50, 10
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+
+		src, err := ioutil.TempDir("", "bench-src")
+		if err != nil {
+			b.Fatal(err)
+		}
+		dst := src + "-dst"
+
+		for p := 0; p < packages; p++ {
+			dir := filepath.Join(src, fmt.Sprintf("package-%d", p))
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				b.Fatal(err)
+			}
+			for f := 0; f < filesPerPackage; f++ {
+				if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("file-%d.js", f)), []byte("module.exports = {}"), 0644); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+
+		b.StartTimer()
+		if err := moveTree(src, dst, CopyOptions{UseRename: false, Concurrency: DefaultCopyConcurrency}); err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+
+		os.RemoveAll(dst)
+	}
+}
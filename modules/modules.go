@@ -0,0 +1,654 @@
+package modules
+
+import (
+	stdcontext "context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildpack/libbuildpack/application"
+	"github.com/cloudfoundry/libcfbuildpack/build"
+	"github.com/cloudfoundry/libcfbuildpack/helper"
+	"github.com/cloudfoundry/libcfbuildpack/layers"
+	"github.com/cloudfoundry/yarn-cnb/modules/remotecache"
+	"github.com/cloudfoundry/yarn-cnb/yarn"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	Dependency     = "node_modules"
+	NodeDependency = "node"
+	Cache          = "cache"
+	ModulesDir     = "node_modules"
+	DirMetadata    = "Node Modules"
+	CacheDir       = "npm-cache"
+	CacheMetaName  = "NPM Cache"
+	PackageLock    = "package-lock.json"
+	YarnLock       = "yarn.lock"
+	YarnRC         = ".yarnrc.yml"
+	PnpmStoreDir   = ".pnpm-store"
+
+	// PnP-specific locations. These are persisted into the node_modules
+	// layer verbatim so that the PnP runtime hooks can be resolved at
+	// launch without a node_modules tree.
+	PnPCJSFile    = ".pnp.cjs"
+	PnPESMLoader  = ".pnp.loader.mjs"
+	YarnCacheDir  = ".yarn/cache"
+	linkerPnP     = "pnp"
+	linkerPnPM    = "pnpm"
+	linkerModules = "node-modules"
+)
+
+//go:generate mockgen -destination=mocks/package_manager.go -package=mocks github.com/cloudfoundry/yarn-cnb/modules PackageManager
+
+// PackageManager is implemented by the concrete yarn/npm/pnpm clients that
+// know how to materialize a node_modules tree (or, for Plug'n'Play, the
+// `.pnp.cjs`/`.yarn/cache` equivalent) for an application.
+type PackageManager interface {
+	// Install and Rebuild take a context so a long-running install can be
+	// cancelled by the platform (e.g. on a build timeout).
+	Install(ctx stdcontext.Context, location, cacheLocation, appRoot string) error
+	Rebuild(ctx stdcontext.Context, cacheLocation, appRoot string) error
+	// InstallImmutable runs a Zero-Installs-aware install against appRoot's
+	// already-vendored `.yarn/cache`, failing rather than resolving anything
+	// that isn't already present in the lockfile/cache.
+	InstallImmutable(appRoot string) error
+	WarnUnmetDependencies(string) error
+}
+
+type MetadataInterface interface {
+	Identity() (name string, version string)
+}
+
+type Metadata struct {
+	Name string
+	Hash string
+}
+
+func (m Metadata) Identity() (name string, version string) {
+	return m.Name, m.Hash
+}
+
+type Contributor struct {
+	NodeModulesMetadata MetadataInterface
+	NPMCacheMetadata    MetadataInterface
+	buildContribution   bool
+	launchContribution  bool
+	pkgManager          PackageManager
+	lockfile            string
+	app                 application.Application
+	nodeModulesLayer    layers.Layer
+	npmCacheLayer       layers.Layer
+	launch              layers.Layers
+	remoteCache         remotecache.RemoteCache
+	hasRemoteCache      bool
+	ctx                 stdcontext.Context
+	copyOptions         CopyOptions
+}
+
+func NewContributor(context build.Build) (Contributor, bool, error) {
+	plan, wantDependency, err := context.Plans.GetShallowMerged(Dependency)
+	if err != nil {
+		return Contributor{}, false, err
+	}
+
+	if !wantDependency {
+		return Contributor{}, false, nil
+	}
+
+	pkgManager, lockfile, err := (PackageManagerResolver{Logger: context.Logger}).Resolve(context.Application.Root)
+	if err != nil {
+		return Contributor{}, false, fmt.Errorf("unable to resolve package manager: %s", err.Error())
+	}
+
+	contributor := Contributor{
+		app:              context.Application,
+		pkgManager:       pkgManager,
+		lockfile:         lockfile,
+		nodeModulesLayer: context.Layers.Layer(Dependency),
+		npmCacheLayer:    context.Layers.Layer(Cache),
+		launch:           context.Layers,
+		ctx:              stdcontext.Background(),
+		copyOptions:      CopyOptions{UseRename: true},
+	}
+
+	remoteCache, hasRemoteCache, err := remotecache.NewFromEnvironment()
+	if err != nil {
+		return Contributor{}, false, fmt.Errorf("unable to configure remote cache: %s", err.Error())
+	}
+	contributor.remoteCache = remoteCache
+	contributor.hasRemoteCache = hasRemoteCache
+
+	if err := contributor.setLayersMetadata(); err != nil {
+		return Contributor{}, false, err
+	}
+
+	contributor.buildContribution, _ = plan.Metadata["build"].(bool)
+	contributor.launchContribution, _ = plan.Metadata["launch"].(bool)
+
+	return contributor, true, nil
+}
+
+// WithContext returns a copy of c that threads ctx through to the package
+// manager's Install/Rebuild calls, so the platform can cancel a long-running
+// install.
+func (c Contributor) WithContext(ctx stdcontext.Context) Contributor {
+	c.ctx = ctx
+	return c
+}
+
+// WithCopyOptions returns a copy of c that uses opts when relocating the
+// installed dependency tree into its layer.
+func (c Contributor) WithCopyOptions(opts CopyOptions) Contributor {
+	c.copyOptions = opts
+	return c
+}
+
+func (c Contributor) Contribute() error {
+	// contributeNodeModules uses the npm-cache layer's root as the package
+	// manager's --cache-folder, so the two contributions can't run fully
+	// independently: contributeManagerCache must restore the vendored/remote
+	// cache into that directory before install/rebuild starts reading and
+	// writing it, and must not tar it up for the remote cache until
+	// install/rebuild has finished. cacheReady and installDone are those two
+	// handoff points; everything else (remote/vendored cache restore,
+	// node_modules detection logic) is independent and safe to run
+	// concurrently.
+	installDone := make(chan struct{})
+	cacheReady := make(chan struct{})
+
+	cacheUpToDate, err := c.npmCacheLayer.MetadataMatches(c.NPMCacheMetadata)
+	if err != nil {
+		return err
+	}
+	if cacheUpToDate {
+		// contributeManagerCache won't run at all, so there's nothing to
+		// restore before install/rebuild can proceed.
+		close(cacheReady)
+	}
+
+	// Use the errgroup's derived context for both contributions, so an error
+	// from either one cancels the pkgManager command still running in the
+	// other instead of letting it run to completion unnecessarily.
+	group, groupCtx := errgroup.WithContext(c.ctx)
+	cc := c
+	cc.ctx = groupCtx
+
+	group.Go(func() error {
+		defer close(installDone)
+		return cc.nodeModulesLayer.Contribute(cc.NodeModulesMetadata, func(layer layers.Layer) error {
+			return cc.contributeNodeModules(layer, cacheReady)
+		}, cc.flags()...)
+	})
+	group.Go(func() error {
+		return cc.npmCacheLayer.Contribute(cc.NPMCacheMetadata, func(layer layers.Layer) error {
+			return cc.contributeManagerCache(layer, installDone, cacheReady)
+		}, layers.Cache)
+	})
+
+	if err := group.Wait(); err != nil {
+		return err
+	}
+
+	return c.launch.WriteApplicationMetadata(layers.Metadata{Processes: []layers.Process{{"web", c.startCommand(), false}}})
+}
+
+// startCommand returns the `yarn`/`pnpm`/`npm start` invocation matching
+// whichever package manager was resolved for this app.
+func (c Contributor) startCommand() string {
+	switch c.lockfile {
+	case PnpmLock:
+		return "pnpm start"
+	case PackageLock:
+		return "npm start"
+	default:
+		return "yarn start"
+	}
+}
+
+func (c Contributor) contributeNodeModules(layer layers.Layer, cacheReady <-chan struct{}) error {
+	if c.lockfile == YarnLock {
+		linker, err := c.nodeLinker()
+		if err != nil {
+			return fmt.Errorf("unable to determine nodeLinker from %s: %s", YarnRC, err.Error())
+		}
+
+		if linker == linkerPnP {
+			return c.contributePnP(layer, cacheReady)
+		}
+
+		if lock, err := readIfExists(filepath.Join(c.app.Root, YarnLock)); err != nil {
+			return fmt.Errorf("unable to read %s: %s", YarnLock, err.Error())
+		} else if lock != nil && yarn.IsBerryLock(lock) {
+			c.nodeModulesLayer.Logger.Info("Detected Yarn Berry with the %q linker", linkerModules)
+		}
+	}
+
+	if hit, err := c.fetchRemoteCache(c.NodeModulesMetadata, layer.Root); err != nil {
+		c.nodeModulesLayer.Logger.Info("Unable to fetch remote node_modules cache: %s", err.Error())
+	} else if hit {
+		c.nodeModulesLayer.Logger.Info("Restored node_modules from remote cache")
+		return c.finishNodeModulesContribution(layer)
+	}
+
+	nodeModules := filepath.Join(c.app.Root, ModulesDir)
+
+	if err := c.tipVendorDependencies(nodeModules); err != nil {
+		return err
+	}
+
+	vendored, err := helper.FileExists(nodeModules)
+	if err != nil {
+		return fmt.Errorf("unable to stat node_modules: %s", err.Error())
+	}
+
+	zeroInstalls := false
+	if c.lockfile == YarnLock {
+		zeroInstalls, err = c.zeroInstalls()
+		if err != nil {
+			return fmt.Errorf("unable to stat %s: %s", YarnCacheDir, err.Error())
+		}
+	}
+
+	switch {
+	case zeroInstalls:
+		c.nodeModulesLayer.Logger.Info("Installing node_modules from vendored Zero-Installs cache")
+		if err := c.pkgManager.InstallImmutable(c.app.Root); err != nil {
+			return fmt.Errorf("unable to install node_modules from Zero-Installs cache: %s", err.Error())
+		}
+	case vendored:
+		// Rebuild uses the npm-cache layer as its --cache-folder/--store-dir,
+		// so it must not start until contributeManagerCache has finished
+		// restoring that directory.
+		<-cacheReady
+		c.nodeModulesLayer.Logger.Info("Rebuilding node_modules")
+		if err := c.pkgManager.Rebuild(c.ctx, c.managerCacheLocation(), c.app.Root); err != nil {
+			return fmt.Errorf("unable to rebuild node_modules: %s", err.Error())
+		}
+	default:
+		<-cacheReady
+		c.nodeModulesLayer.Logger.Info("Installing node_modules")
+		if err := c.pkgManager.Install(c.ctx, layer.Root, c.managerCacheLocation(), c.app.Root); err != nil {
+			return fmt.Errorf("unable to install node_modules: %s", err.Error())
+		}
+	}
+
+	nodeModulesExist, err := helper.FileExists(nodeModules)
+	if err != nil {
+		return fmt.Errorf("unable to stat node_modules: %s", err.Error())
+	}
+
+	if nodeModulesExist {
+		if err := moveTree(nodeModules, filepath.Join(layer.Root, ModulesDir), c.copyOptions); err != nil {
+			return fmt.Errorf(`unable to move "%s" to "%s": %s`, nodeModules, layer.Root, err.Error())
+		}
+	}
+
+	c.uploadRemoteCache(c.NodeModulesMetadata, layer.Root, func(err error) {
+		if err != nil {
+			c.nodeModulesLayer.Logger.Info("Unable to upload remote node_modules cache: %s", err.Error())
+		}
+	})
+
+	return c.finishNodeModulesContribution(layer)
+}
+
+// finishNodeModulesContribution applies the shared environment changes
+// common to both a freshly installed node_modules and one restored from a
+// remote cache hit.
+func (c Contributor) finishNodeModulesContribution(layer layers.Layer) error {
+	if err := os.Setenv("NODE_VERBOSE", "true"); err != nil {
+		return fmt.Errorf("unable to set NODE_VERBOSE to true")
+	}
+
+	if err := c.pkgManager.WarnUnmetDependencies(c.app.Root); err != nil {
+		return fmt.Errorf("failed to check unmet dependencies: %s", err.Error())
+	}
+
+	if err := layer.OverrideSharedEnv("NODE_PATH", filepath.Join(layer.Root, ModulesDir)); err != nil {
+		return err
+	}
+
+	return layer.AppendPathSharedEnv("PATH", filepath.Join(layer.Root, ModulesDir, ".bin"))
+}
+
+// contributePnP persists the PnP runtime hook and its backing zip cache into
+// the layer rather than copying a node_modules tree, since Plug'n'Play apps
+// don't have one. Resolution happens entirely through the hook at launch.
+func (c Contributor) contributePnP(layer layers.Layer, cacheReady <-chan struct{}) error {
+	c.nodeModulesLayer.Logger.Info("Installing Plug'n'Play dependencies")
+
+	zeroInstalls, err := c.zeroInstalls()
+	if err != nil {
+		return fmt.Errorf("unable to stat %s: %s", YarnCacheDir, err.Error())
+	}
+
+	if zeroInstalls {
+		if err := c.pkgManager.InstallImmutable(c.app.Root); err != nil {
+			return fmt.Errorf("unable to install from Zero-Installs cache: %s", err.Error())
+		}
+	} else {
+		// Install uses the npm-cache layer as its --cache-folder, so it must
+		// not start until contributeManagerCache has finished restoring that
+		// directory.
+		<-cacheReady
+		if err := c.pkgManager.Install(c.ctx, layer.Root, c.managerCacheLocation(), c.app.Root); err != nil {
+			return fmt.Errorf("unable to install PnP dependencies: %s", err.Error())
+		}
+	}
+
+	esm, err := c.esmProject()
+	if err != nil {
+		return fmt.Errorf("unable to determine module type: %s", err.Error())
+	}
+
+	hookFile := PnPCJSFile
+	nodeOption := fmt.Sprintf("--require %s", filepath.Join(layer.Root, PnPCJSFile))
+	if esm {
+		hookFile = PnPESMLoader
+		nodeOption = fmt.Sprintf("--loader %s", filepath.Join(layer.Root, PnPESMLoader))
+	}
+
+	for _, entry := range []string{PnPCJSFile, PnPESMLoader, YarnCacheDir} {
+		src := filepath.Join(c.app.Root, entry)
+
+		exists, err := helper.FileExists(src)
+		if err != nil {
+			return fmt.Errorf("unable to stat %s: %s", src, err.Error())
+		}
+		if !exists {
+			continue
+		}
+
+		dst := filepath.Join(layer.Root, entry)
+		if err := moveTree(src, dst, c.copyOptions); err != nil {
+			return fmt.Errorf(`unable to move "%s" to "%s": %s`, src, dst, err.Error())
+		}
+	}
+
+	hookExists, err := helper.FileExists(filepath.Join(layer.Root, hookFile))
+	if err != nil {
+		return fmt.Errorf("unable to stat %s: %s", hookFile, err.Error())
+	}
+	if !hookExists {
+		return fmt.Errorf("%s was not found in %s after installing PnP dependencies", hookFile, c.app.Root)
+	}
+
+	return layer.OverrideSharedEnv("NODE_OPTIONS", nodeOption)
+}
+
+func (c *Contributor) tipVendorDependencies(nodeModules string) error {
+	subdirs, err := hasSubdirs(nodeModules)
+	if err != nil {
+		return err
+	}
+	if !subdirs {
+		c.nodeModulesLayer.Logger.Info("It is recommended to vendor the application's Node.js dependencies")
+	}
+
+	return nil
+}
+
+func hasSubdirs(path string) (bool, error) {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	for _, file := range files {
+		if file.IsDir() {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// cacheSubdir returns the directory, relative to app.Root, that holds the
+// resolved package manager's local cache: `.yarn/cache` for Berry, the
+// pnpm store, or `npm-cache` for classic yarn/npm.
+func (c Contributor) cacheSubdir() string {
+	switch c.lockfile {
+	case YarnLock:
+		if linker, err := c.nodeLinker(); err == nil && (linker == linkerPnP || linker == linkerPnPM) {
+			return YarnCacheDir
+		}
+		return CacheDir
+	case PnpmLock:
+		return PnpmStoreDir
+	default:
+		return CacheDir
+	}
+}
+
+// managerCacheLocation returns the directory within npmCacheLayer.Root that
+// restoreManagerCache populates and that Install/Rebuild must be told to use
+// as their --cache-folder/--store-dir/YARN_CACHE_FOLDER, so a vendored or
+// remotely-restored cache is actually found.
+func (c Contributor) managerCacheLocation() string {
+	return filepath.Join(c.npmCacheLayer.Root, c.cacheSubdir())
+}
+
+func (c Contributor) contributeManagerCache(layer layers.Layer, installDone <-chan struct{}, cacheReady chan<- struct{}) error {
+	hit, err := c.restoreManagerCache(layer)
+	close(cacheReady)
+	if err != nil {
+		return err
+	}
+	if hit {
+		return nil
+	}
+
+	// The node_modules contribution writes into this same layer as the
+	// package manager's --cache-folder, so wait for it to finish before
+	// archiving the directory for upload.
+	<-installDone
+
+	c.uploadRemoteCache(c.NPMCacheMetadata, layer.Root, func(err error) {
+		if err != nil {
+			c.npmCacheLayer.Logger.Info("Unable to upload remote npm-cache: %s", err.Error())
+		}
+	})
+
+	return nil
+}
+
+// restoreManagerCache seeds layer.Root with the package manager's cache
+// directory, either from the remote cache (hit=true) or from a vendored
+// npm-cache/.pnpm-store/.yarn/cache checked into the app, before
+// contributeNodeModules starts using layer.Root as its --cache-folder.
+func (c Contributor) restoreManagerCache(layer layers.Layer) (bool, error) {
+	if err := os.MkdirAll(layer.Root, 0777); err != nil {
+		return false, fmt.Errorf("unable make %s cache layer: %s", c.lockfile, err.Error())
+	}
+
+	if hit, err := c.fetchRemoteCache(c.NPMCacheMetadata, layer.Root); err != nil {
+		c.npmCacheLayer.Logger.Info("Unable to fetch remote cache: %s", err.Error())
+	} else if hit {
+		c.npmCacheLayer.Logger.Info("Restored cache from remote cache")
+		return true, nil
+	}
+
+	cacheSubdir := c.cacheSubdir()
+	managerCache := filepath.Join(c.app.Root, cacheSubdir)
+
+	managerCacheExists, err := helper.FileExists(managerCache)
+	if err != nil {
+		return false, err
+	}
+
+	if managerCacheExists {
+		if err := moveTree(managerCache, filepath.Join(layer.Root, cacheSubdir), c.copyOptions); err != nil {
+			return false, fmt.Errorf(`unable to move "%s" to "%s": %s`, managerCache, layer.Root, err.Error())
+		}
+	}
+
+	return false, nil
+}
+
+// fetchRemoteCache restores a layer directly from the remote cache when one
+// is configured, keyed by metadata's hash, returning ok=false on a miss (or
+// when no remote cache is configured at all) so the caller falls back to a
+// normal local install.
+func (c Contributor) fetchRemoteCache(metadata MetadataInterface, root string) (bool, error) {
+	if !c.hasRemoteCache {
+		return false, nil
+	}
+
+	_, hash := metadata.Identity()
+	return c.remoteCache.Fetch(hash, root)
+}
+
+// uploadRemoteCache pushes root to the remote cache in the background, if
+// one is configured, so a cache miss never blocks the build on the PUT.
+func (c Contributor) uploadRemoteCache(metadata MetadataInterface, root string, onError func(error)) {
+	if !c.hasRemoteCache {
+		return
+	}
+
+	_, hash := metadata.Identity()
+	c.remoteCache.UploadAsync(hash, root, onError)
+}
+
+func (c Contributor) flags() []layers.Flag {
+	flags := []layers.Flag{layers.Cache}
+
+	if c.buildContribution {
+		flags = append(flags, layers.Build)
+	}
+
+	if c.launchContribution {
+		flags = append(flags, layers.Launch)
+	}
+
+	return flags
+}
+
+// nodeLinker returns the `nodeLinker` value configured in .yarnrc.yml (e.g.
+// "pnp", "pnpm" or "node-modules"), or "" if the app has no .yarnrc.yml /
+// doesn't set it, in which case classic node_modules installs apply.
+func (c Contributor) nodeLinker() (string, error) {
+	path := filepath.Join(c.app.Root, YarnRC)
+
+	exists, err := helper.FileExists(path)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", nil
+	}
+
+	out, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	matches := regexp.MustCompile(`(?m)^nodeLinker:\s*(\S+)`).FindSubmatch(out)
+	if matches == nil {
+		return "", nil
+	}
+
+	return strings.Trim(string(matches[1]), `"'`), nil
+}
+
+// zeroInstalls reports whether the app vendors its Yarn Berry dependency
+// cache (.yarn/cache/*.zip), meaning installs can be satisfied immutably
+// without touching the network.
+func (c Contributor) zeroInstalls() (bool, error) {
+	cache := filepath.Join(c.app.Root, YarnCacheDir)
+
+	exists, err := helper.FileExists(cache)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	matches, err := filepath.Glob(filepath.Join(cache, "*.zip"))
+	if err != nil {
+		return false, err
+	}
+
+	return len(matches) > 0, nil
+}
+
+// esmProject reports whether the app's package.json declares "type":
+// "module", which determines whether the PnP hook is loaded via --require
+// or --loader.
+func (c Contributor) esmProject() (bool, error) {
+	out, err := readIfExists(filepath.Join(c.app.Root, "package.json"))
+	if err != nil {
+		return false, err
+	}
+	if out == nil {
+		return false, nil
+	}
+
+	var pkg struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(out, &pkg); err != nil {
+		return false, nil
+	}
+
+	return pkg.Type == "module", nil
+}
+
+func (c *Contributor) setLayersMetadata() error {
+	c.NodeModulesMetadata = Metadata{DirMetadata, strconv.FormatInt(time.Now().UnixNano(), 16)}
+	c.NPMCacheMetadata = Metadata{CacheMetaName, strconv.FormatInt(time.Now().UnixNano(), 16)}
+
+	hash := sha256.New()
+	hashed := false
+
+	if c.lockfile == YarnLock {
+		if out, err := readIfExists(filepath.Join(c.app.Root, YarnRC)); err != nil {
+			return err
+		} else if out != nil {
+			hash.Write(out)
+			hashed = true
+		}
+	}
+
+	lockfile := c.lockfile
+	if lockfile == "" {
+		lockfile = PackageLock
+	}
+
+	if out, err := readIfExists(filepath.Join(c.app.Root, lockfile)); err != nil {
+		return err
+	} else if out != nil {
+		hash.Write(out)
+		hashed = true
+	}
+
+	if hashed {
+		sum := hash.Sum(nil)
+		c.NodeModulesMetadata = Metadata{DirMetadata, hex.EncodeToString(sum)}
+		c.NPMCacheMetadata = Metadata{CacheMetaName, hex.EncodeToString(sum)}
+	}
+
+	return nil
+}
+
+func readIfExists(path string) ([]byte, error) {
+	exists, err := helper.FileExists(path)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	return ioutil.ReadFile(path)
+}
@@ -0,0 +1,70 @@
+package modules
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildpack/libbuildpack/application"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestUnitModules(t *testing.T) {
+	spec.Run(t, "Modules", testModules, spec.Report(report.Terminal{}))
+}
+
+func testModules(t *testing.T, when spec.G, it spec.S) {
+	var (
+		Expect  func(interface{}, ...interface{}) GomegaAssertion
+		appRoot string
+	)
+
+	it.Before(func() {
+		Expect = NewWithT(t).Expect
+
+		var err error
+		appRoot, err = ioutil.TempDir("", "modules")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	it.After(func() {
+		Expect(os.RemoveAll(appRoot)).To(Succeed())
+	})
+
+	when("nodeLinker", func() {
+		it("returns empty when there is no .yarnrc.yml", func() {
+			linker, err := Contributor{app: application.Application{Root: appRoot}}.nodeLinker()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(linker).To(Equal(""))
+		})
+
+		it("parses the configured nodeLinker", func() {
+			Expect(ioutil.WriteFile(filepath.Join(appRoot, YarnRC), []byte("nodeLinker: pnp\n"), 0644)).To(Succeed())
+
+			linker, err := Contributor{app: application.Application{Root: appRoot}}.nodeLinker()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(linker).To(Equal(linkerPnP))
+		})
+	})
+
+	when("zeroInstalls", func() {
+		it("is false when .yarn/cache is not vendored", func() {
+			vendored, err := Contributor{app: application.Application{Root: appRoot}}.zeroInstalls()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vendored).To(BeFalse())
+		})
+
+		it("is true when .yarn/cache contains zip entries", func() {
+			Expect(os.MkdirAll(filepath.Join(appRoot, YarnCacheDir), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(appRoot, YarnCacheDir, "lodash.zip"), []byte{}, 0644)).To(Succeed())
+
+			vendored, err := Contributor{app: application.Application{Root: appRoot}}.zeroInstalls()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vendored).To(BeTrue())
+		})
+	})
+}
@@ -0,0 +1,56 @@
+package remotecache
+
+import (
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// FilesystemBackend stores cache entries as files under Root, named by
+// hash. It backs "file://" cache URLs, primarily useful for local
+// development and CI runners with a shared network volume.
+type FilesystemBackend struct {
+	Root string
+}
+
+func NewFilesystemBackend(root string) FilesystemBackend {
+	return FilesystemBackend{Root: root}
+}
+
+func (f FilesystemBackend) Get(hash string) (io.ReadCloser, bool, error) {
+	file, err := os.Open(filepath.Join(f.Root, hash))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	return file, true, nil
+}
+
+func (f FilesystemBackend) Put(hash string, content io.Reader) error {
+	if err := os.MkdirAll(f.Root, 0755); err != nil {
+		return err
+	}
+
+	out, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(f.Root, hash), out, 0644)
+}
+
+// filePathFromFileURL returns the path component of a "file://" URL, or
+// ok=false if rawURL doesn't use that scheme.
+func filePathFromFileURL(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+
+	return u.Path, true
+}
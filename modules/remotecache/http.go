@@ -0,0 +1,141 @@
+package remotecache
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// HTTPBackend stores cache entries as objects at BaseURL+"/"+hash against
+// any HTTP/S3-style object store that supports GET and PUT.
+type HTTPBackend struct {
+	BaseURL    string
+	Token      string
+	Team       string
+	SignedURLs bool
+	Client     *http.Client
+}
+
+// NewHTTPBackend builds an HTTPBackend. When signedURLs is set, BaseURL is
+// treated as a broker endpoint that hands back a presigned GET/PUT URL for
+// the requested object rather than being requested directly, so the token
+// is only ever used against the broker and never embedded in the object
+// store URL.
+func NewHTTPBackend(baseURL, token, team string, signedURLs bool) HTTPBackend {
+	return HTTPBackend{
+		BaseURL:    baseURL,
+		Token:      token,
+		Team:       team,
+		SignedURLs: signedURLs,
+		Client:     http.DefaultClient,
+	}
+}
+
+func (h HTTPBackend) Get(hash string) (io.ReadCloser, bool, error) {
+	url, err := h.objectURL(hash, http.MethodGet)
+	if err != nil {
+		return nil, false, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if !h.SignedURLs {
+		h.authenticate(req)
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, false, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, false, fmt.Errorf("remote cache GET %q: %s: %s", url, resp.Status, string(body))
+	}
+
+	return resp.Body, true, nil
+}
+
+func (h HTTPBackend) Put(hash string, content io.Reader) error {
+	url, err := h.objectURL(hash, http.MethodPut)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, url, content)
+	if err != nil {
+		return err
+	}
+	if !h.SignedURLs {
+		h.authenticate(req)
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("remote cache PUT %q: %s: %s", url, resp.Status, string(body))
+	}
+
+	return nil
+}
+
+func (h HTTPBackend) objectURL(hash, method string) (string, error) {
+	key := hash
+	if h.Team != "" {
+		key = fmt.Sprintf("%s/%s", h.Team, hash)
+	}
+
+	if !h.SignedURLs {
+		return fmt.Sprintf("%s/%s", h.BaseURL, key), nil
+	}
+
+	return h.sign(key, method)
+}
+
+// sign asks the broker at BaseURL for a presigned URL to GET or PUT the
+// given key directly against the backing object store.
+func (h HTTPBackend) sign(key, method string) (string, error) {
+	signReq, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/sign?key=%s&method=%s", h.BaseURL, key, method), nil)
+	if err != nil {
+		return "", err
+	}
+	h.authenticate(signReq)
+
+	resp, err := h.Client.Do(signReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("unable to sign %q %q: %s: %s", method, key, resp.Status, string(body))
+	}
+
+	signedURL, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	return string(signedURL), nil
+}
+
+func (h HTTPBackend) authenticate(req *http.Request) {
+	if h.Token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", h.Token))
+	}
+}
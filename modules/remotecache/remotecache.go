@@ -0,0 +1,218 @@
+// Package remotecache lets the node_modules and package-manager-cache
+// layers be populated from a shared, out-of-cluster cache instead of
+// always being rebuilt locally on a fresh builder. It is keyed by the same
+// sha256 Contribute uses for layer metadata, so a cache hit is only ever
+// served for bit-identical lockfile input.
+package remotecache
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// EnvURL points at the cache backend, e.g. "https://cache.example.com/node-modules"
+	// or "file:///mnt/shared-cache" for the filesystem backend.
+	EnvURL = "BP_NODE_MODULES_REMOTE_CACHE_URL"
+	// EnvToken is sent as a bearer token on every request to an HTTP backend.
+	EnvToken = "BP_NODE_MODULES_REMOTE_CACHE_TOKEN"
+	// EnvTeam optionally namespaces cache entries so that multiple teams or
+	// orgs can share one backend without colliding on hash alone.
+	EnvTeam = "BP_NODE_MODULES_REMOTE_CACHE_TEAM"
+	// EnvSignedURLs switches the HTTP backend into presigned-URL mode, for
+	// backends (e.g. S3) where the builder should never see long-lived
+	// credentials.
+	EnvSignedURLs = "BP_NODE_MODULES_REMOTE_CACHE_SIGNED_URLS"
+)
+
+// CacheBackend is the minimal GET/PUT surface a remote cache needs to
+// support; HTTP and filesystem implementations are provided in this
+// package, and others can be plugged in by satisfying this interface.
+type CacheBackend interface {
+	// Get returns the cached tarball for hash, or ok=false if it isn't present.
+	Get(hash string) (content io.ReadCloser, ok bool, err error)
+	// Put uploads the tarball for hash, overwriting any existing entry.
+	Put(hash string, content io.Reader) error
+}
+
+// RemoteCache fetches and populates a layer directory from a CacheBackend,
+// content-addressed by the same hash used for layer cache invalidation.
+type RemoteCache struct {
+	Backend CacheBackend
+}
+
+// NewFromEnvironment builds a RemoteCache from BP_NODE_MODULES_REMOTE_CACHE_*
+// environment variables, returning ok=false when no backend is configured
+// so callers can fall back to a purely local build.
+func NewFromEnvironment() (RemoteCache, bool, error) {
+	url := os.Getenv(EnvURL)
+	if url == "" {
+		return RemoteCache{}, false, nil
+	}
+
+	backend, err := NewBackend(url, os.Getenv(EnvToken), os.Getenv(EnvTeam), os.Getenv(EnvSignedURLs) == "true")
+	if err != nil {
+		return RemoteCache{}, false, err
+	}
+
+	return RemoteCache{Backend: backend}, true, nil
+}
+
+// NewBackend resolves a CacheBackend from a URL, dispatching on scheme.
+func NewBackend(rawURL, token, team string, signedURLs bool) (CacheBackend, error) {
+	if u, ok := filePathFromFileURL(rawURL); ok {
+		return NewFilesystemBackend(u), nil
+	}
+
+	return NewHTTPBackend(rawURL, token, team, signedURLs), nil
+}
+
+// Fetch extracts the cached tarball for hash directly into root, returning
+// ok=false on a cache miss so the caller can fall back to a normal install.
+func (c RemoteCache) Fetch(hash, root string) (bool, error) {
+	content, ok, err := c.Backend.Get(hash)
+	if err != nil || !ok {
+		return false, err
+	}
+	defer content.Close()
+
+	if err := extractTarball(content, root); err != nil {
+		return false, fmt.Errorf("unable to extract remote cache entry %q into %q: %s", hash, root, err.Error())
+	}
+
+	return true, nil
+}
+
+// UploadAsync packages root into a tarball and pushes it to the backend
+// under hash in the background, reporting the outcome to onError (if
+// non-nil) with a nil error on success. It returns immediately so a cache
+// miss never blocks the build on the PUT completing.
+func (c RemoteCache) UploadAsync(hash, root string, onError func(error)) {
+	go func() {
+		pipeReader, pipeWriter := io.Pipe()
+
+		go func() {
+			pipeWriter.CloseWithError(writeTarball(pipeWriter, root))
+		}()
+
+		err := c.Backend.Put(hash, pipeReader)
+		if err != nil {
+			err = fmt.Errorf("unable to upload remote cache entry %q: %s", hash, err.Error())
+		}
+
+		if onError != nil {
+			onError(err)
+		}
+	}()
+}
+
+// ContentHash returns the sha256 of a tarball, for embedding alongside an
+// uploaded entry so a future Fetch can verify integrity before trusting it.
+func ContentHash(r io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func writeTarball(w io.Writer, root string) error {
+	gzw := gzip.NewWriter(w)
+	defer gzw.Close()
+
+	tw := tar.NewWriter(gzw)
+	defer tw.Close()
+
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, info.Name())
+		if err != nil {
+			return err
+		}
+		header.Name = rel
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+func extractTarball(r io.Reader, root string) error {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gzr.Close()
+
+	tr := tar.NewReader(gzr)
+	root = filepath.Clean(root)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(root, header.Name)
+		if target != root && !strings.HasPrefix(target, root+string(os.PathSeparator)) {
+			return fmt.Errorf("remote cache entry %q escapes %q", header.Name, root)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.FileMode(header.Mode)); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode))
+			if err != nil {
+				return err
+			}
+
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+			f.Close()
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package remotecache_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudfoundry/yarn-cnb/modules/remotecache"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestUnitRemoteCache(t *testing.T) {
+	spec.Run(t, "RemoteCache", testRemoteCache, spec.Report(report.Terminal{}))
+}
+
+func testRemoteCache(t *testing.T, when spec.G, it spec.S) {
+	var (
+		Expect     func(interface{}, ...interface{}) GomegaAssertion
+		Eventually func(interface{}, ...interface{}) AsyncAssertion
+	)
+
+	it.Before(func() {
+		g := NewWithT(t)
+		Expect = g.Expect
+		Eventually = g.Eventually
+	})
+
+	when("using the filesystem backend", func() {
+		it("round-trips a layer through Fetch/UploadAsync", func() {
+			backendRoot, err := ioutil.TempDir("", "remotecache-backend")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(backendRoot)
+
+			layerRoot, err := ioutil.TempDir("", "remotecache-layer")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(layerRoot)
+
+			Expect(ioutil.WriteFile(filepath.Join(layerRoot, "lodash.js"), []byte("module.exports = {}"), 0644)).To(Succeed())
+
+			cache := remotecache.RemoteCache{Backend: remotecache.NewFilesystemBackend(backendRoot)}
+
+			errs := make(chan error, 1)
+			cache.UploadAsync("deadbeef", layerRoot, func(err error) { errs <- err })
+			Eventually(errs, "1s").Should(Receive(BeNil()))
+
+			restoreRoot, err := ioutil.TempDir("", "remotecache-restore")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(restoreRoot)
+
+			hit, err := cache.Fetch("deadbeef", restoreRoot)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hit).To(BeTrue())
+
+			out, err := ioutil.ReadFile(filepath.Join(restoreRoot, "lodash.js"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(out)).To(Equal("module.exports = {}"))
+		})
+
+		it("misses cleanly when nothing has been uploaded yet", func() {
+			backendRoot, err := ioutil.TempDir("", "remotecache-backend")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(backendRoot)
+
+			cache := remotecache.RemoteCache{Backend: remotecache.NewFilesystemBackend(backendRoot)}
+
+			hit, err := cache.Fetch("does-not-exist", backendRoot)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hit).To(BeFalse())
+		})
+
+		it("rejects a cache entry that tries to write outside of root", func() {
+			backendRoot, err := ioutil.TempDir("", "remotecache-backend")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(backendRoot)
+
+			backend := remotecache.NewFilesystemBackend(backendRoot)
+			Expect(backend.Put("evil", maliciousTarball("../../etc/cron.d/evil"))).To(Succeed())
+
+			restoreRoot, err := ioutil.TempDir("", "remotecache-restore")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(restoreRoot)
+
+			cache := remotecache.RemoteCache{Backend: backend}
+			_, err = cache.Fetch("evil", restoreRoot)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("escapes"))
+		})
+	})
+
+	when("using the HTTP backend", func() {
+		it("authenticates with a bearer token and treats 404 as a miss", func() {
+			var gotAuth string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotAuth = r.Header.Get("Authorization")
+				w.WriteHeader(http.StatusNotFound)
+			}))
+			defer server.Close()
+
+			backend := remotecache.NewHTTPBackend(server.URL, "s3cr3t", "", false)
+
+			_, hit, err := backend.Get("deadbeef")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hit).To(BeFalse())
+			Expect(gotAuth).To(Equal("Bearer s3cr3t"))
+		})
+
+		it("puts the tarball body to the object URL", func() {
+			var gotBody []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotBody, _ = ioutil.ReadAll(r.Body)
+				w.WriteHeader(http.StatusCreated)
+			}))
+			defer server.Close()
+
+			backend := remotecache.NewHTTPBackend(server.URL, "", "", false)
+			Expect(backend.Put("deadbeef", bytes.NewReader([]byte("tarball-bytes")))).To(Succeed())
+			Expect(string(gotBody)).To(Equal("tarball-bytes"))
+		})
+
+		it("in signed-URL mode only authenticates the broker, never the signed object-store request", func() {
+			var brokerAuth, objectAuth string
+			var brokerCalled, objectCalled bool
+
+			objectStore := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				objectCalled = true
+				objectAuth = r.Header.Get("Authorization")
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer objectStore.Close()
+
+			broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				brokerCalled = true
+				brokerAuth = r.Header.Get("Authorization")
+				fmt.Fprint(w, objectStore.URL+"/deadbeef?signature=abc123")
+			}))
+			defer broker.Close()
+
+			backend := remotecache.NewHTTPBackend(broker.URL, "s3cr3t", "", true)
+
+			_, _, err := backend.Get("deadbeef")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(brokerCalled).To(BeTrue())
+			Expect(objectCalled).To(BeTrue())
+			Expect(brokerAuth).To(Equal("Bearer s3cr3t"))
+			Expect(objectAuth).To(BeEmpty())
+		})
+	})
+}
+
+// maliciousTarball builds a gzipped tarball with a single regular file at
+// name, for exercising extractTarball's defense against entries that try to
+// escape the restore root (tar-slip/zip-slip).
+func maliciousTarball(name string) *bytes.Buffer {
+	var buf bytes.Buffer
+
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+
+	contents := []byte("pwned")
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))}); err != nil {
+		panic(err)
+	}
+	if _, err := tw.Write(contents); err != nil {
+		panic(err)
+	}
+
+	if err := tw.Close(); err != nil {
+		panic(err)
+	}
+	if err := gzw.Close(); err != nil {
+		panic(err)
+	}
+
+	return &buf
+}
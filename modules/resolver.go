@@ -0,0 +1,101 @@
+package modules
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libcfbuildpack/helper"
+	"github.com/cloudfoundry/libcfbuildpack/logger"
+	"github.com/cloudfoundry/yarn-cnb/npm"
+	"github.com/cloudfoundry/yarn-cnb/pnpm"
+	"github.com/cloudfoundry/yarn-cnb/yarn"
+)
+
+// PnpmLock is pnpm's lockfile, analogous to YarnLock/PackageLock.
+const PnpmLock = "pnpm-lock.yaml"
+
+// PackageManagerResolver picks the PackageManager that should install an
+// app's dependencies, since a single app tree may be signalled as yarn,
+// pnpm or plain npm by its lockfile or, per Corepack convention, by the
+// `packageManager` field in package.json.
+type PackageManagerResolver struct {
+	Logger logger.Logger
+}
+
+// candidate pairs a lockfile with the manager authoritative for it, in
+// precedence order: yarn.lock wins over pnpm-lock.yaml wins over
+// package-lock.json, matching how Corepack itself breaks ties.
+func (r PackageManagerResolver) candidates() []struct {
+	lockfile string
+	manager  PackageManager
+} {
+	return []struct {
+		lockfile string
+		manager  PackageManager
+	}{
+		{YarnLock, yarn.YARN{Logger: r.Logger}},
+		{PnpmLock, pnpm.PNPM{Logger: r.Logger}},
+		{PackageLock, npm.NPM{Logger: r.Logger}},
+	}
+}
+
+// Resolve returns the PackageManager implementation that should install
+// appRoot's dependencies, along with the name of lockfile that is
+// authoritative for it (so callers can hash the right file for cache
+// invalidation). The Corepack `packageManager` field in package.json, when
+// present, takes precedence over which lockfile happens to exist.
+func (r PackageManagerResolver) Resolve(appRoot string) (PackageManager, string, error) {
+	switch name, err := corepackManager(appRoot); {
+	case err != nil:
+		return nil, "", err
+	case name == "yarn":
+		return yarn.YARN{Logger: r.Logger}, YarnLock, nil
+	case name == "pnpm":
+		return pnpm.PNPM{Logger: r.Logger}, PnpmLock, nil
+	case name == "npm":
+		return npm.NPM{Logger: r.Logger}, PackageLock, nil
+	}
+
+	for _, candidate := range r.candidates() {
+		exists, err := helper.FileExists(filepath.Join(appRoot, candidate.lockfile))
+		if err != nil {
+			return nil, "", err
+		}
+		if exists {
+			return candidate.manager, candidate.lockfile, nil
+		}
+	}
+
+	// No lockfile and no Corepack hint: default to yarn, this buildpack's
+	// namesake package manager.
+	return yarn.YARN{Logger: r.Logger}, YarnLock, nil
+}
+
+// corepackManager reads the Corepack `packageManager` field (e.g.
+// "pnpm@8.6.0") out of package.json, returning "" if it isn't set. A
+// malformed package.json isn't this resolver's problem to report, so it
+// falls through to lockfile detection instead of erroring.
+func corepackManager(appRoot string) (string, error) {
+	out, err := readIfExists(filepath.Join(appRoot, "package.json"))
+	if err != nil {
+		return "", err
+	}
+	if out == nil {
+		return "", nil
+	}
+
+	var pkg struct {
+		PackageManager string `json:"packageManager"`
+	}
+	if err := json.Unmarshal(out, &pkg); err != nil {
+		return "", nil
+	}
+
+	name := pkg.PackageManager
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+
+	return name, nil
+}
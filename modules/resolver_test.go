@@ -0,0 +1,75 @@
+package modules
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestUnitResolver(t *testing.T) {
+	spec.Run(t, "PackageManagerResolver", testResolver, spec.Report(report.Terminal{}))
+}
+
+func testResolver(t *testing.T, when spec.G, it spec.S) {
+	var (
+		Expect  func(interface{}, ...interface{}) GomegaAssertion
+		appRoot string
+	)
+
+	it.Before(func() {
+		Expect = NewWithT(t).Expect
+
+		var err error
+		appRoot, err = ioutil.TempDir("", "resolver")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	it.After(func() {
+		Expect(os.RemoveAll(appRoot)).To(Succeed())
+	})
+
+	when("no lockfile or packageManager field is present", func() {
+		it("defaults to yarn", func() {
+			_, lockfile, err := PackageManagerResolver{}.Resolve(appRoot)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lockfile).To(Equal(YarnLock))
+		})
+	})
+
+	when("a pnpm-lock.yaml is present", func() {
+		it("resolves to pnpm", func() {
+			Expect(ioutil.WriteFile(filepath.Join(appRoot, PnpmLock), []byte{}, 0644)).To(Succeed())
+
+			_, lockfile, err := PackageManagerResolver{}.Resolve(appRoot)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lockfile).To(Equal(PnpmLock))
+		})
+	})
+
+	when("only a package-lock.json is present", func() {
+		it("resolves to npm", func() {
+			Expect(ioutil.WriteFile(filepath.Join(appRoot, PackageLock), []byte{}, 0644)).To(Succeed())
+
+			_, lockfile, err := PackageManagerResolver{}.Resolve(appRoot)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lockfile).To(Equal(PackageLock))
+		})
+	})
+
+	when("package.json declares a Corepack packageManager", func() {
+		it("prefers it over whichever lockfiles exist", func() {
+			Expect(ioutil.WriteFile(filepath.Join(appRoot, "package.json"), []byte(`{"packageManager": "pnpm@8.6.0"}`), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(appRoot, PackageLock), []byte{}, 0644)).To(Succeed())
+
+			_, lockfile, err := PackageManagerResolver{}.Resolve(appRoot)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lockfile).To(Equal(PnpmLock))
+		})
+	})
+}
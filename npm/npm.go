@@ -0,0 +1,59 @@
+package npm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/cloudfoundry/libcfbuildpack/logger"
+)
+
+// NPM implements modules.PackageManager by shelling out to the `npm` binary
+// on PATH. It is the fallback package manager when an app has a
+// package-lock.json (or no lockfile at all) rather than yarn.lock or
+// pnpm-lock.yaml.
+type NPM struct {
+	Logger logger.Logger
+}
+
+func (n NPM) Install(ctx context.Context, location, cacheLocation, appRoot string) error {
+	cmd := exec.CommandContext(ctx, "npm", "ci", fmt.Sprintf("--cache=%s", cacheLocation))
+	return n.run(cmd, appRoot)
+}
+
+func (n NPM) Rebuild(ctx context.Context, cacheLocation, appRoot string) error {
+	cmd := exec.CommandContext(ctx, "npm", "rebuild", fmt.Sprintf("--cache=%s", cacheLocation))
+	return n.run(cmd, appRoot)
+}
+
+// InstallImmutable runs a strictly offline `npm ci`, satisfying the common
+// PackageManager contract for apps that vendor their npm cache.
+func (n NPM) InstallImmutable(appRoot string) error {
+	cmd := exec.Command("npm", "ci", "--offline")
+	return n.run(cmd, appRoot)
+}
+
+func (n NPM) WarnUnmetDependencies(appRoot string) error {
+	cmd := exec.Command("npm", "ls", "--depth=0")
+	cmd.Dir = appRoot
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		n.Logger.Info("Unmet dependencies: %s", string(out))
+	}
+
+	return nil
+}
+
+func (n NPM) run(cmd *exec.Cmd, appRoot string) error {
+	cmd.Dir = appRoot
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error running '%s': %s", cmd.Args, err.Error())
+	}
+
+	return nil
+}
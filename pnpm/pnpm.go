@@ -0,0 +1,57 @@
+package pnpm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/cloudfoundry/libcfbuildpack/logger"
+)
+
+// PNPM implements modules.PackageManager by shelling out to the `pnpm`
+// binary on PATH.
+type PNPM struct {
+	Logger logger.Logger
+}
+
+func (p PNPM) Install(ctx context.Context, location, cacheLocation, appRoot string) error {
+	cmd := exec.CommandContext(ctx, "pnpm", "install", fmt.Sprintf("--store-dir=%s", cacheLocation), "--frozen-lockfile")
+	return p.run(cmd, appRoot)
+}
+
+func (p PNPM) Rebuild(ctx context.Context, cacheLocation, appRoot string) error {
+	cmd := exec.CommandContext(ctx, "pnpm", "rebuild", fmt.Sprintf("--store-dir=%s", cacheLocation))
+	return p.run(cmd, appRoot)
+}
+
+// InstallImmutable installs entirely from the local pnpm store, failing
+// rather than reaching out to the registry for anything missing.
+func (p PNPM) InstallImmutable(appRoot string) error {
+	cmd := exec.Command("pnpm", "install", "--frozen-lockfile", "--offline")
+	return p.run(cmd, appRoot)
+}
+
+func (p PNPM) WarnUnmetDependencies(appRoot string) error {
+	cmd := exec.Command("pnpm", "list", "--depth=0")
+	cmd.Dir = appRoot
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		p.Logger.Info("Unmet dependencies: %s", string(out))
+	}
+
+	return nil
+}
+
+func (p PNPM) run(cmd *exec.Cmd, appRoot string) error {
+	cmd.Dir = appRoot
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error running '%s': %s", cmd.Args, err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package yarn
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libcfbuildpack/logger"
+)
+
+// YARN implements modules.PackageManager by shelling out to the `yarn`
+// binary on PATH, which the node-engine-cnb / yarn-engine layer makes
+// available ahead of this contribution.
+type YARN struct {
+	Logger logger.Logger
+}
+
+func (y YARN) Install(ctx context.Context, location, cacheLocation, appRoot string) error {
+	berry := IsBerry(appRoot)
+
+	var cmd *exec.Cmd
+	if berry {
+		// Berry doesn't understand --modules-folder or --frozen-lockfile: the
+		// former was removed and the latter renamed to --immutable. The cache
+		// location is passed through the environment instead of a flag, since
+		// Berry only recognizes --cache-folder on `yarn cache clean`.
+		cmd = exec.CommandContext(ctx, "yarn", "install", "--immutable", "--non-interactive")
+	} else {
+		cmd = exec.CommandContext(ctx, "yarn", "install", fmt.Sprintf("--modules-folder=%s", location), fmt.Sprintf("--cache-folder=%s", cacheLocation), "--frozen-lockfile", "--non-interactive")
+	}
+
+	return y.run(cmd, appRoot, cacheLocation, berry)
+}
+
+func (y YARN) Rebuild(ctx context.Context, cacheLocation, appRoot string) error {
+	berry := IsBerry(appRoot)
+
+	var cmd *exec.Cmd
+	if berry {
+		cmd = exec.CommandContext(ctx, "yarn", "install", "--immutable", "--non-interactive")
+	} else {
+		cmd = exec.CommandContext(ctx, "yarn", "install", fmt.Sprintf("--cache-folder=%s", cacheLocation), "--frozen-lockfile", "--non-interactive")
+	}
+
+	return y.run(cmd, appRoot, cacheLocation, berry)
+}
+
+// InstallImmutable satisfies a Zero-Installs app entirely from its vendored
+// `.yarn/cache`, failing rather than touching the network or mutating the
+// lockfile.
+func (y YARN) InstallImmutable(appRoot string) error {
+	cmd := exec.Command("yarn", "install", "--immutable", "--immutable-cache")
+	return y.run(cmd, appRoot, "", false)
+}
+
+func (y YARN) WarnUnmetDependencies(appRoot string) error {
+	cmd := exec.Command("yarn", "check")
+	cmd.Dir = appRoot
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		y.Logger.Info("Unmet dependencies: %s", string(out))
+	}
+
+	return nil
+}
+
+func (y YARN) run(cmd *exec.Cmd, appRoot, cacheLocation string, berry bool) error {
+	cmd.Dir = appRoot
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if cacheLocation != "" && berry {
+		cmd.Env = append(os.Environ(), fmt.Sprintf("YARN_CACHE_FOLDER=%s", cacheLocation))
+	}
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error running '%s': %s", cmd.Args, err.Error())
+	}
+
+	return nil
+}
+
+// IsBerry reports whether appRoot's yarn.lock was produced by Yarn 2+
+// (Berry), identifiable by its `__metadata` block absent from classic
+// lockfiles.
+func IsBerry(appRoot string) bool {
+	lock, err := ioutil.ReadFile(filepath.Join(appRoot, "yarn.lock"))
+	if err != nil {
+		return false
+	}
+
+	return IsBerryLock(lock)
+}
+
+// IsBerryLock reports whether an already-read yarn.lock was produced by
+// Yarn 2+ (Berry), for callers that have their own reason to read the file
+// themselves (e.g. to distinguish a read error from a missing lockfile).
+func IsBerryLock(lock []byte) bool {
+	return bytes.Contains(lock, []byte("__metadata:"))
+}
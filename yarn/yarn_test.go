@@ -0,0 +1,63 @@
+package yarn_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudfoundry/yarn-cnb/yarn"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestUnitYarn(t *testing.T) {
+	spec.Run(t, "Yarn", testYarn, spec.Report(report.Terminal{}))
+}
+
+func testYarn(t *testing.T, when spec.G, it spec.S) {
+	var (
+		Expect  func(interface{}, ...interface{}) GomegaAssertion
+		appRoot string
+	)
+
+	it.Before(func() {
+		Expect = NewWithT(t).Expect
+
+		var err error
+		appRoot, err = ioutil.TempDir("", "yarn")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	it.After(func() {
+		Expect(os.RemoveAll(appRoot)).To(Succeed())
+	})
+
+	when("IsBerryLock", func() {
+		it("detects a classic lockfile as not Berry", func() {
+			Expect(yarn.IsBerryLock([]byte("lodash@4.17.21:\n  version \"4.17.21\"\n"))).To(BeFalse())
+		})
+
+		it("detects a Berry lockfile via its __metadata block", func() {
+			Expect(yarn.IsBerryLock([]byte("__metadata:\n  version: 6\n"))).To(BeTrue())
+		})
+	})
+
+	when("IsBerry", func() {
+		it("returns false when appRoot has no yarn.lock", func() {
+			Expect(yarn.IsBerry(appRoot)).To(BeFalse())
+		})
+
+		it("returns false for a classic yarn.lock", func() {
+			Expect(ioutil.WriteFile(filepath.Join(appRoot, "yarn.lock"), []byte("lodash@4.17.21:\n  version \"4.17.21\"\n"), 0644)).To(Succeed())
+			Expect(yarn.IsBerry(appRoot)).To(BeFalse())
+		})
+
+		it("returns true for a Berry yarn.lock", func() {
+			Expect(ioutil.WriteFile(filepath.Join(appRoot, "yarn.lock"), []byte("__metadata:\n  version: 6\n"), 0644)).To(Succeed())
+			Expect(yarn.IsBerry(appRoot)).To(BeTrue())
+		})
+	})
+}